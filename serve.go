@@ -1,18 +1,34 @@
 package serve
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 )
 
+// maxUploadSize caps how large a single upload request's body is allowed to
+// be. It also bounds how much of a multipart upload handleUpload reads into
+// memory before the remainder spills to temp files, since ParseMultipartForm
+// reuses it for that purpose.
+const maxUploadSize = 32 << 20 // 32 MiB
+
 // CLI runs the serve command line app and returns its exit status.
 func CLI(args []string) int {
 	var app app
@@ -27,15 +43,22 @@ func CLI(args []string) int {
 }
 
 type app struct {
-	dir   string
-	quiet bool
-	addr  string
+	dir       string
+	quiet     bool
+	addr      string
+	upload    bool
+	overwrite bool
+	store     store
 }
 
 func (app *app) fromArgs(args []string) error {
 	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
 	flags.StringVar(&app.addr, "a", "localhost:9876", "http address")
 	flags.BoolVar(&app.quiet, "q", false, "use quiet mode - don't display logs")
+	flags.BoolVar(&app.upload, "u", false, "allow file uploads")
+	flags.BoolVar(&app.upload, "upload", false, "allow file uploads")
+	flags.BoolVar(&app.overwrite, "o", false, "allow uploads to overwrite existing files")
+	flags.BoolVar(&app.overwrite, "overwrite", false, "allow uploads to overwrite existing files")
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
@@ -46,9 +69,349 @@ func (app *app) fromArgs(args []string) error {
 		app.dir = fArgs[0]
 	}
 
+	if _, err := app.getStore(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// store abstracts the backing data serve reads from, so app.dir can point at
+// a plain directory or at an archive file browsed in place.
+type store interface {
+	Open(path string) (io.ReadCloser, os.FileInfo, error)
+	ReadDir(path string) ([]os.FileInfo, error)
+	Walk(path string, fn filepath.WalkFunc) error
+}
+
+// getStore returns app's store, lazily resolving it from app.dir if it
+// hasn't been set yet (e.g. when app is built directly rather than through
+// fromArgs).
+func (app *app) getStore() (store, error) {
+	if app.store == nil {
+		s, err := newStore(app.dir)
+		if err != nil {
+			return nil, err
+		}
+		app.store = s
+	}
+	return app.store, nil
+}
+
+// newStore picks a store implementation for dir: a zipStore when dir names a
+// .zip file, a tarStore when dir names a .tar.gz/.tgz file, a fileStore
+// otherwise. A dir that names some other regular file is rejected rather
+// than silently streamed as-is.
+func newStore(dir string) (store, error) {
+	switch {
+	case strings.EqualFold(filepath.Ext(dir), ".zip"):
+		zr, err := zip.OpenReader(dir)
+		if err != nil {
+			return nil, err
+		}
+		return &zipStore{r: &zr.Reader}, nil
+	case isTarGz(dir):
+		return newTarStore(dir)
+	}
+
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		return nil, fmt.Errorf("serve: %s is not a directory, .zip, or .tar.gz archive", dir)
+	}
+
+	return fileStore{root: dir}, nil
+}
+
+// isTarGz reports whether dir names a .tar.gz or .tgz file by extension.
+func isTarGz(dir string) bool {
+	lower := strings.ToLower(dir)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// fileStore serves files from a directory on the local filesystem.
+type fileStore struct {
+	root string
+}
+
+func (s fileStore) Open(path string) (io.ReadCloser, os.FileInfo, error) {
+	f, err := os.Open(filepath.Join(s.root, path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, info, nil
+}
+
+func (s fileStore) ReadDir(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(filepath.Join(s.root, path))
+}
+
+func (s fileStore) Walk(path string, fn filepath.WalkFunc) error {
+	return filepath.Walk(filepath.Join(s.root, path), fn)
+}
+
+// zipStore serves the contents of a zip archive as if it were a directory
+// tree, without unpacking it to disk.
+type zipStore struct {
+	r *zip.Reader
+}
+
+// archiveDirInfo is a synthetic os.FileInfo for directories implied by entry
+// paths in an archive that has no explicit directory entries. Both zipStore
+// and tarStore use it.
+type archiveDirInfo struct {
+	name string
+}
+
+func (d archiveDirInfo) Name() string       { return d.name }
+func (d archiveDirInfo) Size() int64        { return 0 }
+func (d archiveDirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (d archiveDirInfo) ModTime() time.Time { return time.Time{} }
+func (d archiveDirInfo) IsDir() bool        { return true }
+func (d archiveDirInfo) Sys() interface{}   { return nil }
+
+// entryPath normalizes a URL path into the slash-separated, no-leading-slash
+// form archive entry names use.
+func entryPath(path string) string {
+	path = strings.TrimPrefix(filepath.ToSlash(path), "/")
+	if path == "." {
+		path = ""
+	}
+	return path
+}
+
+func (s *zipStore) Open(path string) (io.ReadCloser, os.FileInfo, error) {
+	name := entryPath(path)
+
+	for _, zf := range s.r.File {
+		if strings.TrimSuffix(zf.Name, "/") != name {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		return rc, zf.FileInfo(), nil
+	}
+
+	if name == "" || s.isDir(name) {
+		base := filepath.Base(name)
+		if name == "" {
+			base = "/"
+		}
+		return ioutil.NopCloser(strings.NewReader("")), archiveDirInfo{name: base}, nil
+	}
+
+	return nil, nil, os.ErrNotExist
+}
+
+func (s *zipStore) isDir(name string) bool {
+	prefix := name + "/"
+	for _, zf := range s.r.File {
+		if strings.HasPrefix(zf.Name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *zipStore) ReadDir(path string) ([]os.FileInfo, error) {
+	prefix := entryPath(path)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	seen := map[string]bool{}
+	var infos []os.FileInfo
+	for _, zf := range s.r.File {
+		if !strings.HasPrefix(zf.Name, prefix) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(zf.Name, prefix)
+		rest = strings.TrimSuffix(rest, "/")
+		if rest == "" {
+			continue
+		}
+
+		parts := strings.SplitN(rest, "/", 2)
+		name := parts[0]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if len(parts) > 1 {
+			infos = append(infos, archiveDirInfo{name: name})
+			continue
+		}
+		infos = append(infos, zf.FileInfo())
+	}
+
+	return infos, nil
+}
+
+func (s *zipStore) Walk(path string, fn filepath.WalkFunc) error {
+	return walkStore(s, path, fn)
+}
+
+// walkStore implements store.Walk in terms of ReadDir, shared by the
+// archive-backed stores.
+func walkStore(s store, path string, fn filepath.WalkFunc) error {
+	infos, err := s.ReadDir(path)
+	if err != nil {
+		return fn(path, nil, err)
+	}
+
+	for _, info := range infos {
+		child := filepath.Join(path, info.Name())
+		if err := fn(child, info, nil); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err := walkStore(s, child, fn); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
+// tarEntry holds one file's content and metadata read out of a tar archive.
+// tar lacks zip's central directory, so tarStore reads every entry eagerly
+// at open time to get random access.
+type tarEntry struct {
+	info os.FileInfo
+	data []byte
+}
+
+// tarStore serves the contents of a tar or tar.gz archive as if it were a
+// directory tree, without unpacking it to disk.
+type tarStore struct {
+	entries map[string]*tarEntry
+}
+
+// newTarStore reads path (a .tar.gz, .tgz, or plain .tar file) fully into
+// memory and indexes its entries by name.
+func newTarStore(path string) (*tarStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(strings.ToLower(path), ".tar") {
+		// no-op: r already reads the plain tar stream
+	} else {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	entries := map[string]*tarEntry{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(entryPath(hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		entries[name] = &tarEntry{info: hdr.FileInfo(), data: data}
+	}
+
+	return &tarStore{entries: entries}, nil
+}
+
+func (s *tarStore) Open(path string) (io.ReadCloser, os.FileInfo, error) {
+	name := entryPath(path)
+
+	if e, ok := s.entries[name]; ok {
+		return ioutil.NopCloser(strings.NewReader(string(e.data))), e.info, nil
+	}
+
+	if name == "" || s.isDir(name) {
+		base := filepath.Base(name)
+		if name == "" {
+			base = "/"
+		}
+		return ioutil.NopCloser(strings.NewReader("")), archiveDirInfo{name: base}, nil
+	}
+
+	return nil, nil, os.ErrNotExist
+}
+
+func (s *tarStore) isDir(name string) bool {
+	prefix := name + "/"
+	for entry := range s.entries {
+		if strings.HasPrefix(entry, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *tarStore) ReadDir(path string) ([]os.FileInfo, error) {
+	prefix := entryPath(path)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	seen := map[string]bool{}
+	var infos []os.FileInfo
+	for name, e := range s.entries {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(name, prefix)
+		if rest == "" {
+			continue
+		}
+
+		parts := strings.SplitN(rest, "/", 2)
+		base := parts[0]
+		if seen[base] {
+			continue
+		}
+		seen[base] = true
+
+		if len(parts) > 1 {
+			infos = append(infos, archiveDirInfo{name: base})
+			continue
+		}
+		infos = append(infos, e.info)
+	}
+
+	return infos, nil
+}
+
+func (s *tarStore) Walk(path string, fn filepath.WalkFunc) error {
+	return walkStore(s, path, fn)
+}
+
 func (app *app) run() error {
 	return http.ListenAndServe(app.addr, app.handler())
 }
@@ -59,8 +422,6 @@ func (app *app) handler() http.HandlerFunc {
 			log.Printf("[%s] %s\n", r.Method, r.URL.Path)
 		}
 
-		w.Header().Set("Cache-Control", "no-store")
-
 		switch r.Method {
 		case http.MethodGet:
 			if err := app.handleGet(w, r); err != nil {
@@ -79,42 +440,51 @@ func (app *app) handler() http.HandlerFunc {
 }
 
 func (app *app) handleGet(w http.ResponseWriter, r *http.Request) error {
-	urlPath := filepath.Clean(r.URL.Path)
-	dirPath := filepath.Join(app.dir, urlPath)
-
-	f, err := os.Open(dirPath)
+	s, err := app.getStore()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	info, err := f.Stat()
+	urlPath := filepath.Clean(r.URL.Path)
+
+	f, info, err := s.Open(urlPath)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
 	if info.IsDir() {
-		files, err := ioutil.ReadDir(dirPath)
+		files, err := s.ReadDir(urlPath)
 		if err != nil {
 			return err
 		}
-		serveDir(w, urlPath, files)
+		w.Header().Set("Cache-Control", "no-store")
+		return serveDir(w, r, urlPath, files, app.upload)
+	}
 
-		return nil
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		_, err := io.Copy(w, f)
+		return err
 	}
 
-	_, err = io.Copy(w, f)
-	return err
+	http.ServeContent(w, r, info.Name(), info.ModTime(), rs)
+	return nil
 }
 
 type file struct {
 	Path    string
 	Name    string
-	Size    int64
+	Size    string
 	ModTime string
 	IsDir   bool
 }
 
+type breadcrumb struct {
+	Name string
+	Path string
+}
+
 const listTemplate = `<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -132,13 +502,16 @@ const listTemplate = `<!DOCTYPE html>
 </head>
 <body>
 	<h1>Index of {{.Path}}</h1>
+	<nav>
+		{{range $i, $c := .Breadcrumbs}}{{if $i}} / {{end}}<a href="{{$c.Path}}">{{$c.Name}}</a>{{end}}
+	</nav>
 	<form method="POST">
 		<table>
 			<tr>
 				<th />
-				<th>Name</th>
-				<th>Size</th>
-				<th>Last modified</th>
+				<th><a href="{{.NameSortURL}}">Name</a></th>
+				<th><a href="{{.SizeSortURL}}">Size</a></th>
+				<th><a href="{{.TimeSortURL}}">Last modified</a></th>
 			</tr>
 		{{range .Files}}
 			<tr>
@@ -150,44 +523,363 @@ const listTemplate = `<!DOCTYPE html>
 		{{end}}
 		</table>
 
-		<input type="submit" value="Download zip" />
+		<select name="format">
+			<option value="zip">zip</option>
+			<option value="tar">tar</option>
+			<option value="targz">tar.gz</option>
+		</select>
+		<input type="submit" value="Download" />
+		{{if .Upload}}
+		<input type="file" name="upload" multiple />
+		<input type="submit" value="Upload" formenctype="multipart/form-data" />
+		{{end}}
 	</form>
 </body>
 </html>`
 
-func serveDir(w io.Writer, path string, files []os.FileInfo) error {
+const sortCookieName = "serve_sort"
+
+// serveDir renders a directory listing for path, honoring sort/order/
+// limit/offset query parameters and, when the client asks for JSON, skipping
+// the HTML template entirely.
+func serveDir(w http.ResponseWriter, r *http.Request, path string, infos []os.FileInfo, upload bool) error {
+	sortBy, order := sortPreference(w, r)
+	sortInfos(infos, sortBy, order)
+	infos = paginate(infos, r.URL.Query())
+
+	if wantsJSON(r) {
+		return writeJSONListing(w, path, infos)
+	}
+
+	return writeHTMLListing(w, path, infos, sortBy, order, upload)
+}
+
+// sortPreference resolves the sort/order to use for this request: query
+// parameters win, falling back to the sort cookie, falling back to sorting
+// by name ascending. Explicit query parameters are persisted back to the
+// cookie so the next request without them keeps the same order.
+func sortPreference(w http.ResponseWriter, r *http.Request) (sortBy, order string) {
+	q := r.URL.Query()
+	sortBy, order = q.Get("sort"), q.Get("order")
+
+	if sortBy == "" || order == "" {
+		if c, err := r.Cookie(sortCookieName); err == nil {
+			if parts := strings.SplitN(c.Value, ",", 2); len(parts) == 2 {
+				if sortBy == "" {
+					sortBy = parts[0]
+				}
+				if order == "" {
+					order = parts[1]
+				}
+			}
+		}
+	}
+
+	if sortBy != "name" && sortBy != "size" && sortBy != "time" {
+		sortBy = "name"
+	}
+	if order != "asc" && order != "desc" {
+		order = "asc"
+	}
+
+	if q.Get("sort") != "" || q.Get("order") != "" {
+		http.SetCookie(w, &http.Cookie{Name: sortCookieName, Value: sortBy + "," + order, Path: "/"})
+	}
+
+	return sortBy, order
+}
+
+func sortInfos(infos []os.FileInfo, sortBy, order string) {
+	less := func(i, j int) bool {
+		switch sortBy {
+		case "size":
+			return infos[i].Size() < infos[j].Size()
+		case "time":
+			return infos[i].ModTime().Before(infos[j].ModTime())
+		default:
+			return infos[i].Name() < infos[j].Name()
+		}
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		if sortBy == "name" && infos[i].IsDir() != infos[j].IsDir() {
+			return infos[i].IsDir()
+		}
+		if order == "desc" {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+}
+
+// paginate slices infos according to the offset/limit query parameters.
+// Invalid or missing values are treated as "no pagination".
+func paginate(infos []os.FileInfo, q url.Values) []os.FileInfo {
+	if offset, err := strconv.Atoi(q.Get("offset")); err == nil {
+		if offset < 0 {
+			offset = 0
+		}
+		if offset > len(infos) {
+			offset = len(infos)
+		}
+		infos = infos[offset:]
+	}
+
+	if limit, err := strconv.Atoi(q.Get("limit")); err == nil && limit >= 0 && limit < len(infos) {
+		infos = infos[:limit]
+	}
+
+	return infos
+}
+
+// wantsJSON reports whether the request prefers a JSON listing over the
+// HTML template, either via ?format=json or an Accept header that lists
+// application/json ahead of any text/html or */* entry.
+func wantsJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		switch strings.TrimSpace(strings.SplitN(accept, ";", 2)[0]) {
+		case "application/json":
+			return true
+		case "text/html", "*/*", "":
+			return false
+		}
+	}
+
+	return false
+}
+
+type jsonFile struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+func writeJSONListing(w http.ResponseWriter, path string, infos []os.FileInfo) error {
+	files := make([]jsonFile, 0, len(infos))
+	for _, info := range infos {
+		files = append(files, jsonFile{
+			Name:    info.Name(),
+			Path:    filepath.Join(path, info.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(struct {
+		Path  string     `json:"path"`
+		Files []jsonFile `json:"files"`
+	}{
+		Path:  path,
+		Files: files,
+	})
+}
+
+func writeHTMLListing(w io.Writer, path string, infos []os.FileInfo, sortBy, order string, upload bool) error {
 	t := template.Must(template.New("dirlist").Parse(listTemplate))
 
 	fs := []file{}
-	for _, f := range files {
+	for _, info := range infos {
 		fs = append(fs, file{
-			Name:    f.Name(),
-			Path:    filepath.Join(path, f.Name()),
-			Size:    f.Size(),
-			ModTime: f.ModTime().Format("2006-01-02 15:04:05"),
-			IsDir:   f.IsDir(),
+			Name:    info.Name(),
+			Path:    filepath.Join(path, info.Name()),
+			Size:    humanSize(info.Size()),
+			ModTime: info.ModTime().Format("2006-01-02 15:04:05"),
+			IsDir:   info.IsDir(),
 		})
 	}
 
 	return t.Execute(w, struct {
-		Path  string
-		Files []file
+		Path        string
+		Files       []file
+		Upload      bool
+		Breadcrumbs []breadcrumb
+		NameSortURL string
+		SizeSortURL string
+		TimeSortURL string
 	}{
-		Path:  path,
-		Files: fs,
+		Path:        path,
+		Files:       fs,
+		Upload:      upload,
+		Breadcrumbs: breadcrumbs(path),
+		NameSortURL: sortURL(path, sortBy, order, "name"),
+		SizeSortURL: sortURL(path, sortBy, order, "size"),
+		TimeSortURL: sortURL(path, sortBy, order, "time"),
 	})
 }
 
+// breadcrumbs splits path into linked segments, rooted at "/".
+func breadcrumbs(path string) []breadcrumb {
+	path = strings.Trim(filepath.ToSlash(path), "/")
+
+	crumbs := []breadcrumb{{Name: "root", Path: "/"}}
+	if path == "" {
+		return crumbs
+	}
+
+	acc := ""
+	for _, part := range strings.Split(path, "/") {
+		acc += "/" + part
+		crumbs = append(crumbs, breadcrumb{Name: part, Path: acc})
+	}
+	return crumbs
+}
+
+// sortURL builds the link for a column header: clicking it sorts by col,
+// flipping the order if col is already the active sort.
+func sortURL(path, sortBy, order, col string) string {
+	next := "asc"
+	if sortBy == col && order == "asc" {
+		next = "desc"
+	}
+	v := url.Values{"sort": {col}, "order": {next}}
+	return path + "?" + v.Encode()
+}
+
+// humanSize formats size using binary (KiB, MiB, ...) units.
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
 func (app *app) handlePost(w http.ResponseWriter, r *http.Request) error {
+	if app.upload && strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return app.handleUpload(w, r)
+	}
+
 	if err := r.ParseForm(); err != nil {
 		return err
 	}
 
-	dir := filepath.Join(app.dir, filepath.Clean(r.URL.Path))
+	s, err := app.getStore()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Clean(r.URL.Path)
+
+	format := archiveFormat(r)
+	ext, ok := archiveExtensions[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported archive format: %q", format), http.StatusBadRequest)
+		return nil
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, dirname(dir), ext))
+
+	return archive(w, s, dir, r.Form["files"], format)
+}
+
+// archiveExtensions maps a format name to the file extension used in the
+// Content-Disposition header.
+var archiveExtensions = map[string]string{
+	"zip":   "zip",
+	"tar":   "tar",
+	"targz": "tar.gz",
+}
+
+// archiveMediaTypes maps the Accept header media types clients may send to
+// the format names archiveExtensions and newArchiver understand.
+var archiveMediaTypes = map[string]string{
+	"application/zip":   "zip",
+	"application/x-tar": "tar",
+	"application/gzip":  "targz",
+}
+
+// archiveFormat picks the archive format for a download request: the
+// "format" form field wins, falling back to the Accept header, falling
+// back to zip.
+func archiveFormat(r *http.Request) string {
+	if f := r.Form.Get("format"); f != "" {
+		return f
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt := strings.TrimSpace(strings.SplitN(accept, ";", 2)[0])
+		if format, ok := archiveMediaTypes[mt]; ok {
+			return format
+		}
+	}
+
+	return "zip"
+}
+
+// handleUpload saves the files posted to urlPath on disk, under app.dir.
+// Uploading is only meaningful for a directory-backed store, so it writes
+// straight through os/filepath rather than through the store abstraction.
+func (app *app) handleUpload(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("upload too large: limit is %d bytes", maxUploadSize), http.StatusRequestEntityTooLarge)
+			return nil
+		}
+		return err
+	}
+
+	urlPath := filepath.Clean(r.URL.Path)
+
+	for _, header := range r.MultipartForm.File["upload"] {
+		if strings.Contains(header.Filename, "..") {
+			http.Error(w, fmt.Sprintf("invalid filename: %q", header.Filename), http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	for _, header := range r.MultipartForm.File["upload"] {
+		if err := app.saveUpload(urlPath, header); err != nil {
+			if os.IsExist(err) {
+				http.Error(w, fmt.Sprintf("file already exists: %q", header.Filename), http.StatusConflict)
+				return nil
+			}
+			return err
+		}
+	}
+
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+	return nil
+}
+
+func (app *app) saveUpload(urlPath string, header *multipart.FileHeader) error {
+	src, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if app.overwrite {
+		flags |= os.O_TRUNC
+	} else {
+		flags |= os.O_EXCL
+	}
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, dirname(dir)))
+	dst, err := os.OpenFile(filepath.Join(app.dir, urlPath, filepath.Base(header.Filename)), flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
 
-	return archive(w, dir, r.Form["files"])
+	_, err = io.Copy(dst, src)
+	return err
 }
 
 func dirname(dir string) string {
@@ -198,19 +890,110 @@ func dirname(dir string) string {
 	return base
 }
 
-func archive(w io.Writer, dir string, filenames []string) error {
-	zw := zip.NewWriter(w)
-	defer zw.Close()
+// archiver abstracts the archive format written by the "download" POST
+// endpoint, so zip, tar and tar.gz can share the same tree-walking code.
+type archiver interface {
+	CreateFile(relPath string, fi os.FileInfo) (io.Writer, error)
+	Close() error
+}
+
+// newArchiver picks an archiver implementation for format.
+func newArchiver(w io.Writer, format string) (archiver, error) {
+	switch format {
+	case "zip":
+		return newZipArchiver(w), nil
+	case "tar":
+		return newTarArchiver(w), nil
+	case "targz":
+		return newTargzArchiver(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %q", format)
+	}
+}
+
+type zipArchiver struct {
+	zw *zip.Writer
+}
+
+func newZipArchiver(w io.Writer) *zipArchiver {
+	return &zipArchiver{zw: zip.NewWriter(w)}
+}
+
+func (a *zipArchiver) CreateFile(relPath string, fi os.FileInfo) (io.Writer, error) {
+	hdr, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = relPath
+	hdr.Method = zip.Deflate
+
+	return a.zw.CreateHeader(hdr)
+}
+
+func (a *zipArchiver) Close() error {
+	return a.zw.Close()
+}
+
+type tarArchiver struct {
+	tw *tar.Writer
+}
+
+func newTarArchiver(w io.Writer) *tarArchiver {
+	return &tarArchiver{tw: tar.NewWriter(w)}
+}
+
+func (a *tarArchiver) CreateFile(relPath string, fi os.FileInfo) (io.Writer, error) {
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = relPath
+
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	return a.tw, nil
+}
 
-	fileNames := filenames
-	if len(fileNames) == 0 {
-		if err := addToArchive(zw, dir, dir); err != nil {
+func (a *tarArchiver) Close() error {
+	return a.tw.Close()
+}
+
+type targzArchiver struct {
+	gw *gzip.Writer
+	tarArchiver
+}
+
+func newTargzArchiver(w io.Writer) *targzArchiver {
+	gw := gzip.NewWriter(w)
+	return &targzArchiver{gw: gw, tarArchiver: tarArchiver{tw: tar.NewWriter(gw)}}
+}
+
+func (a *targzArchiver) Close() error {
+	if err := a.tarArchiver.Close(); err != nil {
+		return err
+	}
+	return a.gw.Close()
+}
+
+func archive(w io.Writer, s store, dir string, filenames []string, format string) error {
+	a, err := newArchiver(w, format)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if len(filenames) == 0 {
+		if err := addToArchive(a, s, dir, dir); err != nil {
 			return err
 		}
 	}
 
-	for _, name := range fileNames {
-		if err := addToArchive(zw, dir, filepath.Join(dir, name)); err != nil {
+	for _, name := range filenames {
+		if name == "" || name == "." || name == ".." {
+			continue
+		}
+		if err := addToArchive(a, s, dir, filepath.Join(dir, name)); err != nil {
 			return err
 		}
 	}
@@ -218,42 +1001,37 @@ func archive(w io.Writer, dir string, filenames []string) error {
 	return nil
 }
 
-func addToArchive(zw *zip.Writer, dir, path string) error {
+func addToArchive(a archiver, s store, dir, path string) error {
 	relPath, err := filepath.Rel(dir, path)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.Open(path)
+	f, fi, err := s.Open(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	fi, err := f.Stat()
-	if err != nil {
-		return err
-	}
-
 	if fi.IsDir() {
-		fis, err := ioutil.ReadDir(path)
+		fis, err := s.ReadDir(path)
 		if err != nil {
 			return err
 		}
 
 		for _, fi := range fis {
-			if err := addToArchive(zw, dir, filepath.Join(path, fi.Name())); err != nil {
+			if err := addToArchive(a, s, dir, filepath.Join(path, fi.Name())); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
 
-	zf, err := zw.Create(relPath)
+	dst, err := a.CreateFile(relPath, fi)
 	if err != nil {
 		return err
 	}
 
-	_, err = io.Copy(zf, f)
+	_, err = io.Copy(dst, f)
 	return err
 }
@@ -1,23 +1,33 @@
 package serve
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestFromArgs(t *testing.T) {
 	type testCase struct {
-		args  []string
-		err   error
-		dir   string
-		addr  string
-		quiet bool
+		args      []string
+		err       error
+		dir       string
+		addr      string
+		quiet     bool
+		upload    bool
+		overwrite bool
 	}
 	for name, tc := range map[string]testCase{
 		"default values": {
@@ -48,6 +58,36 @@ func TestFromArgs(t *testing.T) {
 			addr:  "127.0.0.1:1234",
 			quiet: true,
 		},
+		"upload flag": {
+			args:   []string{"-u"},
+			err:    nil,
+			dir:    ".",
+			addr:   "localhost:9876",
+			upload: true,
+		},
+		"overwrite flag": {
+			args:      []string{"-u", "-o"},
+			err:       nil,
+			dir:       ".",
+			addr:      "localhost:9876",
+			upload:    true,
+			overwrite: true,
+		},
+		"long upload flag": {
+			args:   []string{"--upload"},
+			err:    nil,
+			dir:    ".",
+			addr:   "localhost:9876",
+			upload: true,
+		},
+		"long overwrite flag": {
+			args:      []string{"--upload", "--overwrite"},
+			err:       nil,
+			dir:       ".",
+			addr:      "localhost:9876",
+			upload:    true,
+			overwrite: true,
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			var app app
@@ -64,6 +104,12 @@ func TestFromArgs(t *testing.T) {
 			if app.quiet != tc.quiet {
 				t.Errorf("quiet should be %v; got %v", tc.quiet, app.quiet)
 			}
+			if app.upload != tc.upload {
+				t.Errorf("upload should be %v; got %v", tc.upload, app.upload)
+			}
+			if app.overwrite != tc.overwrite {
+				t.Errorf("overwrite should be %v; got %v", tc.overwrite, app.overwrite)
+			}
 		})
 	}
 }
@@ -124,6 +170,801 @@ func TestHandler(t *testing.T) {
 	}
 }
 
+func TestHandleGetRange(t *testing.T) {
+	content := "0123456789"
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte(content), 0644); err != nil {
+		t.Fatalf("Cannot write temp file: %v", err)
+	}
+
+	type testCase struct {
+		rangeHeader string
+		status      int
+		body        string
+	}
+	for name, tc := range map[string]testCase{
+		"no range": {
+			rangeHeader: "",
+			status:      http.StatusOK,
+			body:        content,
+		},
+		"start only": {
+			rangeHeader: "bytes=0-4",
+			status:      http.StatusPartialContent,
+			body:        "01234",
+		},
+		"suffix": {
+			rangeHeader: "bytes=-5",
+			status:      http.StatusPartialContent,
+			body:        "56789",
+		},
+		"open ended": {
+			rangeHeader: "bytes=3-",
+			status:      http.StatusPartialContent,
+			body:        "3456789",
+		},
+		"oversized end": {
+			rangeHeader: "bytes=0-100",
+			status:      http.StatusPartialContent,
+			body:        content,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := app{dir: tmpDir}
+			h := app.handler()
+
+			req := httptest.NewRequest("GET", "/file.txt", nil)
+			if tc.rangeHeader != "" {
+				req.Header.Set("Range", tc.rangeHeader)
+			}
+			resp := httptest.NewRecorder()
+
+			h(resp, req)
+
+			if resp.Code != tc.status {
+				t.Errorf("StatusCode should be %v; got %v", tc.status, resp.Code)
+			}
+			if resp.Body.String() != tc.body {
+				t.Errorf("Body should be %v; got %v", tc.body, resp.Body.String())
+			}
+		})
+	}
+
+	t.Run("unsatisfiable", func(t *testing.T) {
+		app := app{dir: tmpDir}
+		h := app.handler()
+
+		req := httptest.NewRequest("GET", "/file.txt", nil)
+		req.Header.Set("Range", "bytes=100-200")
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusRequestedRangeNotSatisfiable, resp.Code)
+		}
+	})
+}
+
+func TestHandleGetMultiRange(t *testing.T) {
+	content := "0123456789"
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte(content), 0644); err != nil {
+		t.Fatalf("Cannot write temp file: %v", err)
+	}
+
+	app := app{dir: tmpDir}
+	h := app.handler()
+
+	req := httptest.NewRequest("GET", "/file.txt", nil)
+	req.Header.Set("Range", "bytes=0-1,5-8")
+	resp := httptest.NewRecorder()
+
+	h(resp, req)
+
+	if resp.Code != http.StatusPartialContent {
+		t.Errorf("StatusCode should be %v; got %v", http.StatusPartialContent, resp.Code)
+	}
+	if ct := resp.Header().Get("Content-Type"); !strings.HasPrefix(ct, "multipart/byteranges") {
+		t.Errorf("Content-Type should start with multipart/byteranges; got %v", ct)
+	}
+}
+
+func TestHandleGetConditional(t *testing.T) {
+	content := "0123456789"
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte(content), 0644); err != nil {
+		t.Fatalf("Cannot write temp file: %v", err)
+	}
+
+	app := app{dir: tmpDir}
+	h := app.handler()
+
+	req := httptest.NewRequest("GET", "/file.txt", nil)
+	req.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	resp := httptest.NewRecorder()
+
+	h(resp, req)
+
+	if resp.Code != http.StatusNotModified {
+		t.Errorf("StatusCode should be %v; got %v", http.StatusNotModified, resp.Code)
+	}
+	if resp.Body.Len() != 0 {
+		t.Errorf("Body should be empty; got %v", resp.Body.String())
+	}
+}
+
+func TestHandleGetFromZip(t *testing.T) {
+	content := "hello from zip"
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	zipPath := filepath.Join(tmpDir, "site.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatalf("Cannot create site.zip file: %v", err)
+	}
+
+	zw := zip.NewWriter(f)
+	fw, err := zw.Create("sub/file.txt")
+	if err != nil {
+		t.Fatalf("Cannot create zip entry: %v", err)
+	}
+	if _, err := fw.Write([]byte(content)); err != nil {
+		t.Fatalf("Cannot write zip entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Cannot close zip writer: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Cannot close site.zip file: %v", err)
+	}
+
+	app := app{dir: zipPath}
+	h := app.handler()
+
+	t.Run("root listing", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusOK, resp.Code)
+		}
+		if !strings.Contains(resp.Body.String(), "sub") {
+			t.Errorf("Body should list %q; got %v", "sub", resp.Body.String())
+		}
+	})
+
+	t.Run("nested listing", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/sub", nil)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusOK, resp.Code)
+		}
+		if !strings.Contains(resp.Body.String(), "file.txt") {
+			t.Errorf("Body should list %q; got %v", "file.txt", resp.Body.String())
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/sub/file.txt", nil)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusOK, resp.Code)
+		}
+		if resp.Body.String() != content {
+			t.Errorf("Body should be %v; got %v", content, resp.Body.String())
+		}
+	})
+}
+
+func TestHandleGetFromTarGz(t *testing.T) {
+	content := "hello from tar.gz"
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tgzPath := filepath.Join(tmpDir, "site.tar.gz")
+	f, err := os.Create(tgzPath)
+	if err != nil {
+		t.Fatalf("Cannot create site.tar.gz file: %v", err)
+	}
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{Name: "sub/file.txt", Size: int64(len(content)), Mode: 0644}); err != nil {
+		t.Fatalf("Cannot write tar header: %v", err)
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatalf("Cannot write tar entry: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Cannot close tar writer: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("Cannot close gzip writer: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Cannot close site.tar.gz file: %v", err)
+	}
+
+	app := app{dir: tgzPath}
+	h := app.handler()
+
+	t.Run("root listing", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusOK, resp.Code)
+		}
+		if !strings.Contains(resp.Body.String(), "sub") {
+			t.Errorf("Body should list %q; got %v", "sub", resp.Body.String())
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/sub/file.txt", nil)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusOK, resp.Code)
+		}
+		if resp.Body.String() != content {
+			t.Errorf("Body should be %v; got %v", content, resp.Body.String())
+		}
+	})
+}
+
+func TestNewStoreRejectsPlainFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "notes.txt")
+	if err := ioutil.WriteFile(path, []byte("just a file"), 0644); err != nil {
+		t.Fatalf("Cannot write file: %v", err)
+	}
+
+	if _, err := newStore(path); err == nil {
+		t.Errorf("newStore(%q) should return an error for a non-archive file", path)
+	}
+}
+
+func TestHandleUpload(t *testing.T) {
+	buildBody := func(t *testing.T, filename, content string) (io.Reader, string) {
+		t.Helper()
+
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+
+		fw, err := mw.CreateFormFile("upload", filename)
+		if err != nil {
+			t.Fatalf("Cannot create form file: %v", err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("Cannot write form file: %v", err)
+		}
+		if err := mw.Close(); err != nil {
+			t.Fatalf("Cannot close multipart writer: %v", err)
+		}
+
+		return &buf, mw.FormDataContentType()
+	}
+
+	t.Run("saves file", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("Cannot create temp directory: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		body, contentType := buildBody(t, "hello.txt", "hello world")
+
+		app := app{dir: tmpDir, upload: true}
+		h := app.handler()
+
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", contentType)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusSeeOther {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusSeeOther, resp.Code)
+		}
+
+		got, err := ioutil.ReadFile(filepath.Join(tmpDir, "hello.txt"))
+		if err != nil {
+			t.Fatalf("Cannot read uploaded file: %v", err)
+		}
+		if string(got) != "hello world" {
+			t.Errorf("Uploaded file should contain %q; got %q", "hello world", string(got))
+		}
+	})
+
+	t.Run("rejects path traversal", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("Cannot create temp directory: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		// mime/multipart already reduces a "/"-separated filename to its
+		// base name, so exercise the guard with a separator it doesn't
+		// normalize on this platform.
+		body, contentType := buildBody(t, `..\evil.txt`, "nope")
+
+		app := app{dir: tmpDir, upload: true}
+		h := app.handler()
+
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", contentType)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusBadRequest, resp.Code)
+		}
+
+		entries, err := ioutil.ReadDir(tmpDir)
+		if err != nil {
+			t.Fatalf("Cannot read temp directory: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("no file should have been written; got %v", entries)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("Cannot create temp directory: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		body, contentType := buildBody(t, "disabled.txt", "nope")
+
+		app := app{dir: tmpDir}
+		h := app.handler()
+
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", contentType)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusOK, resp.Code)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, "disabled.txt")); !os.IsNotExist(err) {
+			t.Errorf("disabled.txt should not have been written")
+		}
+	})
+
+	t.Run("rejects oversized upload", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("Cannot create temp directory: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		body, contentType := buildBody(t, "big.bin", strings.Repeat("x", maxUploadSize+1))
+
+		app := app{dir: tmpDir, upload: true}
+		h := app.handler()
+
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", contentType)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusRequestEntityTooLarge, resp.Code)
+		}
+
+		entries, err := ioutil.ReadDir(tmpDir)
+		if err != nil {
+			t.Fatalf("Cannot read temp directory: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("no file should have been written; got %v", entries)
+		}
+	})
+
+	t.Run("409s on existing file by default", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("Cannot create temp directory: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, "hello.txt"), []byte("original"), 0644); err != nil {
+			t.Fatalf("Cannot write existing file: %v", err)
+		}
+
+		body, contentType := buildBody(t, "hello.txt", "replacement")
+
+		app := app{dir: tmpDir, upload: true}
+		h := app.handler()
+
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", contentType)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusConflict {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusConflict, resp.Code)
+		}
+
+		got, err := ioutil.ReadFile(filepath.Join(tmpDir, "hello.txt"))
+		if err != nil {
+			t.Fatalf("Cannot read file: %v", err)
+		}
+		if string(got) != "original" {
+			t.Errorf("existing file should be untouched; got %q", string(got))
+		}
+	})
+
+	t.Run("overwrites existing file when enabled", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("Cannot create temp directory: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, "hello.txt"), []byte("original"), 0644); err != nil {
+			t.Fatalf("Cannot write existing file: %v", err)
+		}
+
+		body, contentType := buildBody(t, "hello.txt", "replacement")
+
+		app := app{dir: tmpDir, upload: true, overwrite: true}
+		h := app.handler()
+
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", contentType)
+		resp := httptest.NewRecorder()
+
+		h(resp, req)
+
+		if resp.Code != http.StatusSeeOther {
+			t.Errorf("StatusCode should be %v; got %v", http.StatusSeeOther, resp.Code)
+		}
+
+		got, err := ioutil.ReadFile(filepath.Join(tmpDir, "hello.txt"))
+		if err != nil {
+			t.Fatalf("Cannot read file: %v", err)
+		}
+		if string(got) != "replacement" {
+			t.Errorf("file should be overwritten; got %q", string(got))
+		}
+	})
+}
+
+func newListingTestDir(t *testing.T) string {
+	t.Helper()
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	files := []struct {
+		name    string
+		content string
+		modTime time.Time
+	}{
+		{"b_small.txt", "a", time.Now().Add(-2 * time.Hour)},
+		{"a_big.txt", strings.Repeat("x", 2048), time.Now().Add(-1 * time.Hour)},
+		{"c_medium.txt", "abcde", time.Now()},
+	}
+	for _, f := range files {
+		path := filepath.Join(tmpDir, f.name)
+		if err := ioutil.WriteFile(path, []byte(f.content), 0644); err != nil {
+			t.Fatalf("Cannot write %v: %v", f.name, err)
+		}
+		if err := os.Chtimes(path, f.modTime, f.modTime); err != nil {
+			t.Fatalf("Cannot set mtime for %v: %v", f.name, err)
+		}
+	}
+
+	return tmpDir
+}
+
+func listedNames(t *testing.T, body string) []string {
+	t.Helper()
+
+	var names []string
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.Contains(line, `<input type="checkbox" name="files" value="`) {
+			continue
+		}
+		const marker = `value="`
+		start := strings.Index(line, marker) + len(marker)
+		end := strings.Index(line[start:], `"`)
+		names = append(names, line[start:start+end])
+	}
+	return names
+}
+
+func TestServeDirSort(t *testing.T) {
+	tmpDir := newListingTestDir(t)
+
+	type testCase struct {
+		query string
+		want  []string
+	}
+	for name, tc := range map[string]testCase{
+		"name asc": {
+			query: "?sort=name&order=asc",
+			want:  []string{"a_big.txt", "b_small.txt", "c_medium.txt"},
+		},
+		"name desc": {
+			query: "?sort=name&order=desc",
+			want:  []string{"c_medium.txt", "b_small.txt", "a_big.txt"},
+		},
+		"size asc": {
+			query: "?sort=size&order=asc",
+			want:  []string{"b_small.txt", "c_medium.txt", "a_big.txt"},
+		},
+		"time desc": {
+			query: "?sort=time&order=desc",
+			want:  []string{"c_medium.txt", "a_big.txt", "b_small.txt"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := app{dir: tmpDir}
+			h := app.handler()
+
+			req := httptest.NewRequest("GET", "/"+tc.query, nil)
+			resp := httptest.NewRecorder()
+
+			h(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("StatusCode should be %v; got %v", http.StatusOK, resp.Code)
+			}
+
+			got := listedNames(t, resp.Body.String())
+			if len(got) != len(tc.want) {
+				t.Fatalf("names should be %v; got %v", tc.want, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("names should be %v; got %v", tc.want, got)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestServeDirPagination(t *testing.T) {
+	tmpDir := newListingTestDir(t)
+
+	type testCase struct {
+		query string
+		want  []string
+	}
+	for name, tc := range map[string]testCase{
+		"limit": {
+			query: "?sort=name&order=asc&limit=2",
+			want:  []string{"a_big.txt", "b_small.txt"},
+		},
+		"offset": {
+			query: "?sort=name&order=asc&offset=1",
+			want:  []string{"b_small.txt", "c_medium.txt"},
+		},
+		"offset and limit": {
+			query: "?sort=name&order=asc&offset=1&limit=1",
+			want:  []string{"b_small.txt"},
+		},
+		"offset past end": {
+			query: "?sort=name&order=asc&offset=100",
+			want:  nil,
+		},
+		"negative offset": {
+			query: "?sort=name&order=asc&offset=-5",
+			want:  []string{"a_big.txt", "b_small.txt", "c_medium.txt"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := app{dir: tmpDir}
+			h := app.handler()
+
+			req := httptest.NewRequest("GET", "/"+tc.query, nil)
+			resp := httptest.NewRecorder()
+
+			h(resp, req)
+
+			got := listedNames(t, resp.Body.String())
+			if len(got) != len(tc.want) {
+				t.Fatalf("names should be %v; got %v", tc.want, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("names should be %v; got %v", tc.want, got)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestServeDirJSON(t *testing.T) {
+	tmpDir := newListingTestDir(t)
+
+	type testCase struct {
+		target string
+		header string
+	}
+	for name, tc := range map[string]testCase{
+		"query param": {
+			target: "/?format=json",
+		},
+		"accept header": {
+			target: "/",
+			header: "application/json",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := app{dir: tmpDir}
+			h := app.handler()
+
+			req := httptest.NewRequest("GET", tc.target, nil)
+			if tc.header != "" {
+				req.Header.Set("Accept", tc.header)
+			}
+			resp := httptest.NewRecorder()
+
+			h(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("StatusCode should be %v; got %v", http.StatusOK, resp.Code)
+			}
+			if ct := resp.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type should be application/json; got %v", ct)
+			}
+
+			var listing struct {
+				Path  string `json:"path"`
+				Files []struct {
+					Name  string `json:"name"`
+					Path  string `json:"path"`
+					Size  int64  `json:"size"`
+					IsDir bool   `json:"isDir"`
+				} `json:"files"`
+			}
+			if err := json.Unmarshal(resp.Body.Bytes(), &listing); err != nil {
+				t.Fatalf("Cannot unmarshal JSON response: %v", err)
+			}
+			if listing.Path != "/" {
+				t.Errorf("Path should be %v; got %v", "/", listing.Path)
+			}
+			if len(listing.Files) != 3 {
+				t.Errorf("Files should have 3 entries; got %v", len(listing.Files))
+			}
+		})
+	}
+}
+
+func TestServeDirBreadcrumbs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "sub", "nested"), 0755); err != nil {
+		t.Fatalf("Cannot create nested directory: %v", err)
+	}
+
+	app := app{dir: tmpDir}
+	h := app.handler()
+
+	req := httptest.NewRequest("GET", "/sub/nested", nil)
+	resp := httptest.NewRecorder()
+
+	h(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("StatusCode should be %v; got %v", http.StatusOK, resp.Code)
+	}
+
+	for _, want := range []string{`<a href="/">root</a>`, `<a href="/sub">sub</a>`, `<a href="/sub/nested">nested</a>`} {
+		if !strings.Contains(resp.Body.String(), want) {
+			t.Errorf("Body should contain %v; got %v", want, resp.Body.String())
+		}
+	}
+}
+
+func TestServeDirSortCookie(t *testing.T) {
+	tmpDir := newListingTestDir(t)
+
+	app := app{dir: tmpDir}
+	h := app.handler()
+
+	req := httptest.NewRequest("GET", "/?sort=size&order=desc", nil)
+	resp := httptest.NewRecorder()
+
+	h(resp, req)
+
+	var cookie *http.Cookie
+	for _, c := range resp.Result().Cookies() {
+		if c.Name == sortCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatalf("Response should set the %v cookie", sortCookieName)
+	}
+	if cookie.Value != "size,desc" {
+		t.Errorf("Cookie value should be %v; got %v", "size,desc", cookie.Value)
+	}
+
+	req2 := httptest.NewRequest("GET", "/", nil)
+	req2.AddCookie(cookie)
+	resp2 := httptest.NewRecorder()
+
+	h(resp2, req2)
+
+	got := listedNames(t, resp2.Body.String())
+	want := []string{"a_big.txt", "c_medium.txt", "b_small.txt"}
+	if len(got) != len(want) {
+		t.Fatalf("names should be %v; got %v", want, got)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("names should be %v; got %v", want, got)
+			break
+		}
+	}
+}
+
 func TestArchive(t *testing.T) {
 	type testCase struct {
 		files     map[string]string
@@ -177,7 +1018,7 @@ func TestArchive(t *testing.T) {
 				}
 			}()
 
-			if err := archive(f, tmpDir, tc.filenames); err != nil {
+			if err := archive(f, fileStore{root: tmpDir}, "/", tc.filenames, "zip"); err != nil {
 				t.Errorf("Error should be nil; got %v", err)
 			}
 
@@ -231,3 +1072,115 @@ func TestArchive(t *testing.T) {
 		})
 	}
 }
+
+// TestArchiveIgnoresStrayEmptyFilename guards against a stray "" entry in
+// filenames (e.g. an unselected <input type="file"> serialized alongside
+// selection checkboxes sharing its form field name) being resolved to dir
+// itself and silently archiving the whole tree instead of nothing.
+func TestArchiveIgnoresStrayEmptyFilename(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "asdf"), []byte("content"), 0644); err != nil {
+		t.Fatalf("Cannot write temp file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "qwer"), []byte("content"), 0644); err != nil {
+		t.Fatalf("Cannot write temp file: %v", err)
+	}
+
+	for _, name := range []string{"", ".", ".."} {
+		t.Run(fmt.Sprintf("filename %q", name), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := archive(&buf, fileStore{root: tmpDir}, "/", []string{"asdf", name}, "zip"); err != nil {
+				t.Errorf("Error should be nil; got %v", err)
+			}
+
+			r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("Cannot open archive: %v", err)
+			}
+
+			if len(r.File) != 1 || r.File[0].Name != "asdf" {
+				var got []string
+				for _, f := range r.File {
+					got = append(got, f.Name)
+				}
+				t.Errorf("archive should only contain [asdf]; got %v", got)
+			}
+		})
+	}
+}
+
+func TestArchiveFormats(t *testing.T) {
+	files := map[string]string{
+		"asdf": "this is the content of asdf",
+		"qwer": "this is the content of qwer",
+	}
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0640); err != nil {
+			t.Fatalf("Cannot write temp file: %v", err)
+		}
+	}
+
+	for _, format := range []string{"tar", "targz"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := archive(&buf, fileStore{root: tmpDir}, "/", nil, format); err != nil {
+				t.Fatalf("Error should be nil; got %v", err)
+			}
+
+			var tr *tar.Reader
+			if format == "targz" {
+				gr, err := gzip.NewReader(&buf)
+				if err != nil {
+					t.Fatalf("Cannot open gzip reader: %v", err)
+				}
+				defer gr.Close()
+				tr = tar.NewReader(gr)
+			} else {
+				tr = tar.NewReader(&buf)
+			}
+
+			got := map[string]string{}
+			modes := map[string]os.FileMode{}
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Cannot read tar entry: %v", err)
+				}
+
+				b, err := ioutil.ReadAll(tr)
+				if err != nil {
+					t.Fatalf("Cannot read %v in archive: %v", hdr.Name, err)
+				}
+				got[hdr.Name] = string(b)
+				modes[hdr.Name] = os.FileMode(hdr.Mode)
+			}
+
+			if len(got) != len(files) {
+				t.Errorf("Number of files in archive should be %v; got %v", len(files), len(got))
+			}
+			for name, content := range files {
+				if got[name] != content {
+					t.Errorf("%v should contain `%v`; got `%v`", name, content, got[name])
+				}
+				if modes[name].Perm() != 0640 {
+					t.Errorf("%v should have mode %v; got %v", name, os.FileMode(0640), modes[name].Perm())
+				}
+			}
+		})
+	}
+}